@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+const (
+	authMethodTokenFile  = "token-file"
+	authMethodKubernetes = "kubernetes"
+	authMethodAppRole    = "approle"
+	authMethodJWT        = "jwt"
+)
+
+// Authenticator obtains a Vault token for the synchronizer to use,
+// allowing it to run outside of the vault-kubernetes-auth sidecar.
+type Authenticator interface {
+	// Authenticate returns the api.Secret carrying the token to use. For
+	// login-based methods this is the login response, whose lease can be
+	// handed to a renewer; for token-file it carries only the token.
+	Authenticate(client *api.Client) (*api.Secret, error)
+}
+
+// newAuthenticator builds the Authenticator selected by VAULT_AUTH_METHOD.
+// The default, token-file, preserves the original behaviour of reading a
+// pre-issued token from VAULT_TOKEN_PATH.
+func newAuthenticator() (Authenticator, error) {
+	method := os.Getenv("VAULT_AUTH_METHOD")
+	if method == "" {
+		method = authMethodTokenFile
+	}
+	mountPath := os.Getenv("VAULT_AUTH_MOUNT_PATH")
+
+	switch method {
+	case authMethodTokenFile:
+		tokenPath := os.Getenv("VAULT_TOKEN_PATH")
+		if tokenPath == "" {
+			return nil, fmt.Errorf("missing VAULT_TOKEN_PATH")
+		}
+		return &tokenFileAuthenticator{Path: tokenPath}, nil
+
+	case authMethodKubernetes:
+		if mountPath == "" {
+			mountPath = "kubernetes"
+		}
+		role := os.Getenv("VAULT_AUTH_KUBERNETES_ROLE")
+		if role == "" {
+			return nil, fmt.Errorf("missing VAULT_AUTH_KUBERNETES_ROLE")
+		}
+		jwtPath := os.Getenv("VAULT_AUTH_KUBERNETES_TOKEN_PATH")
+		if jwtPath == "" {
+			jwtPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+		}
+		return &kubernetesAuthenticator{MountPath: mountPath, Role: role, JWTPath: jwtPath}, nil
+
+	case authMethodAppRole:
+		if mountPath == "" {
+			mountPath = "approle"
+		}
+		roleID := os.Getenv("VAULT_AUTH_APPROLE_ROLE_ID")
+		if roleID == "" {
+			return nil, fmt.Errorf("missing VAULT_AUTH_APPROLE_ROLE_ID")
+		}
+		secretID := os.Getenv("VAULT_AUTH_APPROLE_SECRET_ID")
+		if secretID == "" {
+			return nil, fmt.Errorf("missing VAULT_AUTH_APPROLE_SECRET_ID")
+		}
+		return &appRoleAuthenticator{MountPath: mountPath, RoleID: roleID, SecretID: secretID}, nil
+
+	case authMethodJWT:
+		if mountPath == "" {
+			mountPath = "jwt"
+		}
+		role := os.Getenv("VAULT_AUTH_JWT_ROLE")
+		if role == "" {
+			return nil, fmt.Errorf("missing VAULT_AUTH_JWT_ROLE")
+		}
+		jwtPath := os.Getenv("VAULT_AUTH_JWT_TOKEN_PATH")
+		if jwtPath == "" {
+			return nil, fmt.Errorf("missing VAULT_AUTH_JWT_TOKEN_PATH")
+		}
+		return &jwtAuthenticator{MountPath: mountPath, Role: role, JWTPath: jwtPath}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported VAULT_AUTH_METHOD %q", method)
+	}
+}
+
+// tokenFileAuthenticator reads a pre-issued Vault token from a file, as
+// written by the vault-kubernetes-auth init container.
+type tokenFileAuthenticator struct {
+	Path string
+}
+
+func (a *tokenFileAuthenticator) Authenticate(client *api.Client) (*api.Secret, error) {
+	content, err := ioutil.ReadFile(a.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get vault token")
+	}
+	token := strings.TrimSpace(string(content))
+	return &api.Secret{Auth: &api.SecretAuth{ClientToken: token}}, nil
+}
+
+// kubernetesAuthenticator logs in to Vault's kubernetes auth method by
+// posting the pod's projected service account JWT to auth/<mount>/login.
+type kubernetesAuthenticator struct {
+	MountPath string
+	Role      string
+	JWTPath   string
+}
+
+func (a *kubernetesAuthenticator) Authenticate(client *api.Client) (*api.Secret, error) {
+	jwt, err := ioutil.ReadFile(a.JWTPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read kubernetes service account token")
+	}
+	return login(client, a.MountPath, map[string]interface{}{
+		"role": a.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+}
+
+// appRoleAuthenticator logs in to Vault's approle auth method with a
+// role ID and secret ID.
+type appRoleAuthenticator struct {
+	MountPath string
+	RoleID    string
+	SecretID  string
+}
+
+func (a *appRoleAuthenticator) Authenticate(client *api.Client) (*api.Secret, error) {
+	return login(client, a.MountPath, map[string]interface{}{
+		"role_id":   a.RoleID,
+		"secret_id": a.SecretID,
+	})
+}
+
+// jwtAuthenticator logs in to Vault's jwt/oidc auth method with a static
+// bound JWT read from JWTPath.
+type jwtAuthenticator struct {
+	MountPath string
+	Role      string
+	JWTPath   string
+}
+
+func (a *jwtAuthenticator) Authenticate(client *api.Client) (*api.Secret, error) {
+	jwt, err := ioutil.ReadFile(a.JWTPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read jwt token")
+	}
+	return login(client, a.MountPath, map[string]interface{}{
+		"role": a.Role,
+		"jwt":  strings.TrimSpace(string(jwt)),
+	})
+}
+
+// login posts data to auth/<mountPath>/login and returns the resulting
+// secret, failing if Vault did not hand back a token.
+func login(client *api.Client, mountPath string, data map[string]interface{}) (*api.Secret, error) {
+	s, err := client.Logical().Write(fmt.Sprintf("auth/%s/login", mountPath), data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "vault login at auth/%s failed", mountPath)
+	}
+	if s == nil || s.Auth == nil || s.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("vault login at auth/%s returned no token", mountPath)
+	}
+	return s, nil
+}
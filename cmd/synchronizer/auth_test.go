@@ -0,0 +1,109 @@
+package main
+
+import "testing"
+
+func TestNewAuthenticator(t *testing.T) {
+	t.Run("defaults to token-file", func(t *testing.T) {
+		t.Setenv("VAULT_AUTH_METHOD", "")
+		t.Setenv("VAULT_TOKEN_PATH", "/var/run/secrets/vault-token")
+		a, err := newAuthenticator()
+		if err != nil {
+			t.Fatalf("newAuthenticator: %v", err)
+		}
+		tf, ok := a.(*tokenFileAuthenticator)
+		if !ok {
+			t.Fatalf("got %T, want *tokenFileAuthenticator", a)
+		}
+		if tf.Path != "/var/run/secrets/vault-token" {
+			t.Errorf("Path = %q", tf.Path)
+		}
+	})
+
+	t.Run("token-file requires VAULT_TOKEN_PATH", func(t *testing.T) {
+		t.Setenv("VAULT_AUTH_METHOD", "token-file")
+		t.Setenv("VAULT_TOKEN_PATH", "")
+		if _, err := newAuthenticator(); err == nil {
+			t.Fatal("expected error for missing VAULT_TOKEN_PATH")
+		}
+	})
+
+	t.Run("kubernetes defaults mount path and token path", func(t *testing.T) {
+		t.Setenv("VAULT_AUTH_METHOD", "kubernetes")
+		t.Setenv("VAULT_AUTH_MOUNT_PATH", "")
+		t.Setenv("VAULT_AUTH_KUBERNETES_ROLE", "my-role")
+		t.Setenv("VAULT_AUTH_KUBERNETES_TOKEN_PATH", "")
+		a, err := newAuthenticator()
+		if err != nil {
+			t.Fatalf("newAuthenticator: %v", err)
+		}
+		k, ok := a.(*kubernetesAuthenticator)
+		if !ok {
+			t.Fatalf("got %T, want *kubernetesAuthenticator", a)
+		}
+		if k.MountPath != "kubernetes" {
+			t.Errorf("MountPath = %q, want kubernetes", k.MountPath)
+		}
+		if k.Role != "my-role" {
+			t.Errorf("Role = %q, want my-role", k.Role)
+		}
+		if k.JWTPath != "/var/run/secrets/kubernetes.io/serviceaccount/token" {
+			t.Errorf("JWTPath = %q", k.JWTPath)
+		}
+	})
+
+	t.Run("kubernetes requires role", func(t *testing.T) {
+		t.Setenv("VAULT_AUTH_METHOD", "kubernetes")
+		t.Setenv("VAULT_AUTH_KUBERNETES_ROLE", "")
+		if _, err := newAuthenticator(); err == nil {
+			t.Fatal("expected error for missing VAULT_AUTH_KUBERNETES_ROLE")
+		}
+	})
+
+	t.Run("approle requires role id and secret id", func(t *testing.T) {
+		t.Setenv("VAULT_AUTH_METHOD", "approle")
+		t.Setenv("VAULT_AUTH_APPROLE_ROLE_ID", "")
+		t.Setenv("VAULT_AUTH_APPROLE_SECRET_ID", "")
+		if _, err := newAuthenticator(); err == nil {
+			t.Fatal("expected error for missing VAULT_AUTH_APPROLE_ROLE_ID")
+		}
+
+		t.Setenv("VAULT_AUTH_APPROLE_ROLE_ID", "role-id")
+		if _, err := newAuthenticator(); err == nil {
+			t.Fatal("expected error for missing VAULT_AUTH_APPROLE_SECRET_ID")
+		}
+
+		t.Setenv("VAULT_AUTH_APPROLE_SECRET_ID", "secret-id")
+		a, err := newAuthenticator()
+		if err != nil {
+			t.Fatalf("newAuthenticator: %v", err)
+		}
+		ar, ok := a.(*appRoleAuthenticator)
+		if !ok {
+			t.Fatalf("got %T, want *appRoleAuthenticator", a)
+		}
+		if ar.RoleID != "role-id" || ar.SecretID != "secret-id" {
+			t.Errorf("unexpected authenticator: %+v", ar)
+		}
+	})
+
+	t.Run("jwt requires role and token path", func(t *testing.T) {
+		t.Setenv("VAULT_AUTH_METHOD", "jwt")
+		t.Setenv("VAULT_AUTH_JWT_ROLE", "")
+		t.Setenv("VAULT_AUTH_JWT_TOKEN_PATH", "")
+		if _, err := newAuthenticator(); err == nil {
+			t.Fatal("expected error for missing VAULT_AUTH_JWT_ROLE")
+		}
+
+		t.Setenv("VAULT_AUTH_JWT_ROLE", "my-role")
+		if _, err := newAuthenticator(); err == nil {
+			t.Fatal("expected error for missing VAULT_AUTH_JWT_TOKEN_PATH")
+		}
+	})
+
+	t.Run("unsupported method", func(t *testing.T) {
+		t.Setenv("VAULT_AUTH_METHOD", "bogus")
+		if _, err := newAuthenticator(); err == nil {
+			t.Fatal("expected error for unsupported VAULT_AUTH_METHOD")
+		}
+	})
+}
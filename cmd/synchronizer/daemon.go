@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// checksumAnnotation is set on a rolled workload's pod template whenever
+// one of its dependent secrets changes, so Kubernetes rolls its pods.
+const checksumAnnotation = "vault-secret/checksum"
+
+// rolloutTarget identifies a workload whose pod template should be
+// annotated when one of its dependent secrets rotates.
+type rolloutTarget struct {
+	Kind string // "Deployment" or "StatefulSet"
+	Name string
+}
+
+// parseRolloutTargets parses ROLLOUT_TARGETS, a comma-separated list of
+// "secret=Kind/name[+Kind/name...]" entries, into a secret name -> targets
+// map. An empty string yields an empty map.
+func parseRolloutTargets(env string) (map[string][]rolloutTarget, error) {
+	targets := make(map[string][]rolloutTarget)
+	for _, item := range strings.Split(env, ",") {
+		if len(item) == 0 {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid ROLLOUT_TARGETS entry %q, expected secret=Kind/name", item)
+		}
+		for _, ref := range strings.Split(parts[1], "+") {
+			refParts := strings.SplitN(ref, "/", 2)
+			if len(refParts) != 2 {
+				return nil, fmt.Errorf("invalid ROLLOUT_TARGETS reference %q, expected Kind/name", ref)
+			}
+			targets[parts[0]] = append(targets[parts[0]], rolloutTarget{Kind: refParts[0], Name: refParts[1]})
+		}
+	}
+	return targets, nil
+}
+
+// runDaemon runs synchronize on a SYNC_INTERVAL loop, renewing the Vault
+// token in the background and rolling dependent workloads when a secret
+// changes, until it receives SIGTERM/SIGINT or the token renewal dies.
+func (c *config) runDaemon(authSecret *api.Secret) error {
+	token, err := authSecret.TokenID()
+	if err != nil {
+		return errors.Wrap(err, "could not get vault token id")
+	}
+	c.vaultClient.SetToken(token)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+
+	renewalDone := make(chan error, 1)
+	if watcher, err := c.startTokenRenewal(authSecret); err != nil {
+		log.Println(errors.Wrap(err, "token renewal disabled"))
+	} else {
+		go watcher.Start()
+		defer watcher.Stop()
+		go watchRenewal(watcher, renewalDone)
+	}
+
+	m := newMetrics()
+	go func() {
+		if err := m.serve(c.MetricsAddr); err != nil {
+			log.Println(errors.Wrap(err, "metrics server stopped"))
+		}
+	}()
+
+	c.syncOnce(m)
+
+	ticker := time.NewTicker(c.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.syncOnce(m)
+		case err := <-renewalDone:
+			if err != nil {
+				return errors.Wrap(err, "vault token renewal failed")
+			}
+			return fmt.Errorf("vault token renewal stopped unexpectedly")
+		case sig := <-stop:
+			log.Println("received", sig, "- shutting down")
+			return nil
+		}
+	}
+}
+
+// watchRenewal forwards a LifetimeWatcher's terminal outcome to done,
+// logging each successful renewal along the way.
+func watchRenewal(watcher *api.LifetimeWatcher, done chan<- error) {
+	for {
+		select {
+		case err := <-watcher.DoneCh():
+			done <- err
+			return
+		case <-watcher.RenewCh():
+			log.Println("vault token renewed")
+		}
+	}
+}
+
+// startTokenRenewal starts a LifetimeWatcher for the token the
+// Authenticator obtained. authSecret's own lease is used when the
+// authenticator performed a login (kubernetes, approle, jwt); token-file
+// secrets carry no lease of their own, so the token is looked up to see
+// whether Vault considers it renewable.
+func (c *config) startTokenRenewal(authSecret *api.Secret) (*api.LifetimeWatcher, error) {
+	secret := authSecret
+	if secret.Auth == nil || !secret.Auth.Renewable {
+		looked, err := c.vaultClient.Auth().Token().LookupSelf()
+		if err != nil {
+			return nil, errors.Wrap(err, "could not look up vault token")
+		}
+		renewable, _ := looked.TokenIsRenewable()
+		if !renewable {
+			return nil, fmt.Errorf("vault token is not renewable")
+		}
+		secret = looked
+	}
+	return c.vaultClient.NewLifetimeWatcher(&api.LifetimeWatcherInput{Secret: secret})
+}
+
+// syncOnce synchronizes every configured secret, recording per-secret
+// metrics and rolling dependent workloads on change. Like
+// synchronizeBestEffort, a single secret's failure never aborts the
+// rest of the run - the daemon has no "whole pod" to fail.
+func (c *config) syncOnce(m *metrics) {
+	report := newSyncReport()
+	for k, v := range c.Secrets {
+		identity, changed, err := c.applySecret(k, v)
+		if err != nil {
+			log.Println(errors.Wrapf(err, "failed to sync secret %s", k.String()))
+			m.recordFailure(k.String())
+			report.recordFailure(k.String(), err)
+			continue
+		}
+		m.recordSuccess(k.String(), time.Now())
+		report.recordSuccess(k.String())
+		if changed {
+			c.rollSecretDependents(k.Name, identity)
+		}
+	}
+	c.cleanupObsoleteSecrets()
+
+	if err := c.publishReport(report); err != nil {
+		log.Println(errors.Wrap(err, "failed to publish sync status configmap"))
+	}
+}
+
+// rollSecretDependents bumps the checksum annotation on every workload
+// registered for secret in ROLLOUT_TARGETS.
+func (c *config) rollSecretDependents(secret, identity string) {
+	for _, t := range c.RolloutTargets[secret] {
+		if err := c.bumpChecksum(t, identity); err != nil {
+			log.Println(errors.Wrapf(err, "failed to roll %s/%s for secret %s", t.Kind, t.Name, secret))
+		}
+	}
+}
+
+// bumpChecksum sets the checksumAnnotation on a Deployment or
+// StatefulSet's pod template so Kubernetes rolls its pods.
+func (c *config) bumpChecksum(t rolloutTarget, identity string) error {
+	checksum := hashOf(map[string]interface{}{"identity": identity})
+	switch t.Kind {
+	case "Deployment":
+		d, err := c.k8sClientset.AppsV1().Deployments(c.Namespace).Get(t.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if d.Spec.Template.Annotations == nil {
+			d.Spec.Template.Annotations = make(map[string]string)
+		}
+		d.Spec.Template.Annotations[checksumAnnotation] = checksum
+		_, err = c.k8sClientset.AppsV1().Deployments(c.Namespace).Update(d)
+		return err
+	case "StatefulSet":
+		s, err := c.k8sClientset.AppsV1().StatefulSets(c.Namespace).Get(t.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if s.Spec.Template.Annotations == nil {
+			s.Spec.Template.Annotations = make(map[string]string)
+		}
+		s.Spec.Template.Annotations[checksumAnnotation] = checksum
+		_, err = c.k8sClientset.AppsV1().StatefulSets(c.Namespace).Update(s)
+		return err
+	default:
+		return fmt.Errorf("unsupported rollout target kind %q", t.Kind)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestParseRolloutTargets(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		targets, err := parseRolloutTargets("")
+		if err != nil {
+			t.Fatalf("parseRolloutTargets: %v", err)
+		}
+		if len(targets) != 0 {
+			t.Fatalf("targets = %+v, want empty", targets)
+		}
+	})
+
+	t.Run("single target", func(t *testing.T) {
+		targets, err := parseRolloutTargets("db-creds=Deployment/api")
+		if err != nil {
+			t.Fatalf("parseRolloutTargets: %v", err)
+		}
+		want := []rolloutTarget{{Kind: "Deployment", Name: "api"}}
+		got := targets["db-creds"]
+		if len(got) != 1 || got[0] != want[0] {
+			t.Fatalf("targets[db-creds] = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("multiple secrets and + separated targets", func(t *testing.T) {
+		targets, err := parseRolloutTargets("db-creds=Deployment/api+StatefulSet/worker,tls=Deployment/web")
+		if err != nil {
+			t.Fatalf("parseRolloutTargets: %v", err)
+		}
+		wantDB := []rolloutTarget{{Kind: "Deployment", Name: "api"}, {Kind: "StatefulSet", Name: "worker"}}
+		gotDB := targets["db-creds"]
+		if len(gotDB) != len(wantDB) {
+			t.Fatalf("targets[db-creds] = %+v, want %+v", gotDB, wantDB)
+		}
+		for i := range wantDB {
+			if gotDB[i] != wantDB[i] {
+				t.Fatalf("targets[db-creds][%d] = %+v, want %+v", i, gotDB[i], wantDB[i])
+			}
+		}
+		wantTLS := []rolloutTarget{{Kind: "Deployment", Name: "web"}}
+		gotTLS := targets["tls"]
+		if len(gotTLS) != 1 || gotTLS[0] != wantTLS[0] {
+			t.Fatalf("targets[tls] = %+v, want %+v", gotTLS, wantTLS)
+		}
+	})
+
+	t.Run("missing =", func(t *testing.T) {
+		if _, err := parseRolloutTargets("db-creds"); err == nil {
+			t.Fatal("expected error for entry without '='")
+		}
+	})
+
+	t.Run("missing /", func(t *testing.T) {
+		if _, err := parseRolloutTargets("db-creds=Deployment"); err == nil {
+			t.Fatal("expected error for reference without '/'")
+		}
+	})
+}
@@ -1,9 +1,12 @@
 // synchronizer synchronizes Vault secrets with Kubernetes secrets
 //
-// synchronizer expects a valid Vault token in VAULT_TOKEN_PATH (see authenticator)
+// synchronizer authenticates to Vault via the Authenticator selected by
+// VAULT_AUTH_METHOD (token-file by default, reading a token written by
+// the vault-kubernetes-auth sidecar from VAULT_TOKEN_PATH)
 // all Kubernetes secrets receive an annotation to identify and delete them as synchronized secrets when they are no longer needed
 //
-// synchronizer is meant to be used in an init container on Kubernetes.
+// synchronizer is meant to be used in an init container on Kubernetes, or
+// as a standalone MODE=daemon process.
 package main
 
 import (
@@ -11,8 +14,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"path"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/vault/api"
 	"github.com/pkg/errors"
@@ -24,68 +27,187 @@ import (
 )
 
 const (
-	vaultAnnotation = "vault-secret"
+	vaultAnnotation      = "vault-secret"
+	ownerAnnotation      = "vault-secret/owner"
+	sourceHashAnnotation = "vault-secret/source-hash"
+
+	modeOneshot = "oneshot"
+	modeDaemon  = "daemon"
+
+	defaultSyncInterval = 30 * time.Second
+	defaultMetricsAddr  = ":9090"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run builds the config, authenticates and synchronizes, returning the
+// process exit code. Keeping construction failures as typed errors
+// instead of calling log.Fatal lets this dispatcher be the only place
+// that decides whether to terminate the process, which also keeps
+// newFromEnvironment exercisable outside of a real Kubernetes pod.
+func run() int {
 	c, err := newFromEnvironment()
 	if err != nil {
-		log.Fatal(errors.Wrap(err, "failed to get config"))
+		log.Println(errors.Wrap(err, "failed to get config"))
+		return 1
 	}
 
-	token, err := c.loadToken()
+	secret, err := c.Authenticator.Authenticate(c.vaultClient)
 	if err != nil {
 		if err := c.checkSecrets(); err != nil {
-			log.Fatal(err)
+			log.Println(err)
+			return 1
 		}
 		// you get only here if ALLOW_FAIL=true was set for vault-kubernetes-auth Init Container and vault-kubernetes-auth failed to authenticate
 		log.Println(errors.Wrap(err, "cannot synchronize secrets - all secrets seems to be available therefore pod creation will continue"))
-		os.Exit(0)
+		return 0
+	}
+
+	if c.Mode == modeDaemon {
+		if err := c.runDaemon(secret); err != nil {
+			log.Println(errors.Wrap(err, "daemon stopped"))
+			return 1
+		}
+		return 0
 	}
 
-	if err := c.synchronize(token); err != nil {
-		log.Fatal(errors.Wrap(err, "failed to synchronize secrets"))
+	if err := c.synchronize(secret); err != nil {
+		log.Println(errors.Wrap(err, "failed to synchronize secrets"))
+		return 1
 	}
 	log.Printf("secrets successfully synchronized")
+	return 0
+}
+
+// secretKey identifies one VAULT_SECRETS mapping by its resolved target
+// namespace together with its kubernetes secret name. Keying on name
+// alone would collapse a secret distributed under the same name to
+// several namespaces (e.g. vault/app/db:app-db@team-a and
+// vault/app/db:app-db@team-b) into a single entry, silently dropping one
+// mapping and, worse, letting cleanupNamespace mistake one tenant's
+// secret for another's and delete it.
+type secretKey struct {
+	Namespace string
+	Name      string
+}
 
-	os.Exit(0)
+func (k secretKey) String() string {
+	return k.Namespace + "/" + k.Name
 }
 
 type config struct {
-	VaultTokenPath string
-	Secrets        map[string]string // key = kubernetes secret name, value = vault secret name
-	Namespace      string
-	k8sClientset   *kubernetes.Clientset
-	vaultClient    *api.Client
+	Authenticator    Authenticator
+	Secrets          map[secretKey]*secretMapping // key = target namespace + kubernetes secret name
+	Namespace        string
+	KVVersion        kvVersion                  // default KV version, "" means auto-detect
+	KVMounts         map[string]kvVersion       // per-mount KV version overrides, keyed by mount path
+	Mode             string                     // oneshot (default) or daemon
+	SyncInterval     time.Duration              // daemon mode: how often to re-poll Vault
+	MetricsAddr      string                     // daemon mode: listen address for /healthz and /metrics
+	RolloutTargets   map[string][]rolloutTarget // daemon mode: secret name -> workloads to roll on change
+	InstanceID       string                     // identifies this synchronizer in the owner annotation
+	ClusterScoped    bool                       // when true, cleanup considers secrets in every namespace
+	LabelSelector    string                     // LABEL_SELECTOR passed to secret List calls
+	FailureMode      string                     // fail-fast (default) or best-effort
+	k8sClientset     *kubernetes.Clientset
+	vaultClient      *api.Client
+	kvVersions       map[string]kvVersion // cache of auto-detected mount versions
+	secretIdentities map[secretKey]string // daemon mode: last applied identity per secret
 }
 
 func newFromEnvironment() (*config, error) {
 	c := &config{}
-	c.VaultTokenPath = os.Getenv("VAULT_TOKEN_PATH")
-	if c.VaultTokenPath == "" {
-		return nil, fmt.Errorf("missing VAULT_TOKEN_PATH")
+
+	// current kubernetes namespace - read first, since resolving each
+	// VAULT_SECRETS entry's target namespace below needs it.
+	content, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get namespace")
 	}
-	c.Secrets = make(map[string]string)
-	for _, item := range strings.Split(os.Getenv("VAULT_SECRETS"), ",") {
+	c.Namespace = strings.TrimSpace(string(content))
+
+	c.Secrets = make(map[secretKey]*secretMapping)
+	for _, item := range splitUnescaped(os.Getenv("VAULT_SECRETS"), ',') {
 		if len(item) == 0 {
 			continue
 		}
-		s := strings.Split(item, ":")
-		k := path.Base(s[0])
-		if len(s) > 1 {
-			k = s[1]
+		m, err := parseSecretMapping(item)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid VAULT_SECRETS entry %q", item)
+		}
+		key := secretKey{Namespace: c.targetNamespace(m), Name: m.Name}
+		if _, exists := c.Secrets[key]; exists {
+			return nil, fmt.Errorf("duplicate VAULT_SECRETS entry for secret %s in namespace %s", key.Name, key.Namespace)
 		}
-		c.Secrets[k] = s[0]
+		c.Secrets[key] = m
 	}
 	if len(c.Secrets) == 0 {
 		return nil, fmt.Errorf("no secrets to synchronize - check VAULT_SECRETS")
 	}
-	// current kubernetes namespace
-	content, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+	if v := os.Getenv("VAULT_KV_VERSION"); v != "" {
+		c.KVVersion = kvVersion(v)
+	}
+	c.KVMounts = make(map[string]kvVersion)
+	for _, item := range strings.Split(os.Getenv("VAULT_KV_MOUNTS"), ",") {
+		if len(item) == 0 {
+			continue
+		}
+		s := strings.SplitN(item, "=", 2)
+		if len(s) != 2 {
+			return nil, fmt.Errorf("invalid VAULT_KV_MOUNTS entry %q, expected mount=version", item)
+		}
+		c.KVMounts[s[0]] = kvVersion(s[1])
+	}
+	c.kvVersions = make(map[string]kvVersion)
+	c.secretIdentities = make(map[secretKey]string)
+
+	c.Mode = os.Getenv("MODE")
+	if c.Mode == "" {
+		c.Mode = modeOneshot
+	}
+	if c.Mode != modeOneshot && c.Mode != modeDaemon {
+		return nil, fmt.Errorf("invalid MODE %q, expected %q or %q", c.Mode, modeOneshot, modeDaemon)
+	}
+	c.SyncInterval = defaultSyncInterval
+	if v := os.Getenv("SYNC_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid SYNC_INTERVAL")
+		}
+		c.SyncInterval = d
+	}
+	c.MetricsAddr = os.Getenv("METRICS_ADDR")
+	if c.MetricsAddr == "" {
+		c.MetricsAddr = defaultMetricsAddr
+	}
+	rolloutTargets, err := parseRolloutTargets(os.Getenv("ROLLOUT_TARGETS"))
 	if err != nil {
-		return nil, errors.Wrap(err, "could not get namespace")
+		return nil, err
 	}
-	c.Namespace = strings.TrimSpace(string(content))
+	c.RolloutTargets = rolloutTargets
+
+	// INSTANCE_ID must be set explicitly to something stable across
+	// rollouts (e.g. the owning Deployment/StatefulSet name): the pod
+	// hostname changes on every reschedule, which would make
+	// cleanupNamespace's ownership check never match this syncer's own
+	// previously-created secrets again, leaking them forever.
+	c.InstanceID = os.Getenv("INSTANCE_ID")
+	if c.InstanceID == "" {
+		return nil, fmt.Errorf("missing INSTANCE_ID")
+	}
+	c.ClusterScoped = os.Getenv("CLUSTER_SCOPED") == "true"
+	c.LabelSelector = os.Getenv("LABEL_SELECTOR")
+
+	c.FailureMode = os.Getenv("FAILURE_MODE")
+	if c.FailureMode == "" {
+		c.FailureMode = failureModeFailFast
+	}
+	if c.FailureMode != failureModeFailFast && c.FailureMode != failureModeBestEffort {
+		return nil, fmt.Errorf("invalid FAILURE_MODE %q, expected %q or %q", c.FailureMode, failureModeFailFast, failureModeBestEffort)
+	}
+
 	// connect to kubernetes
 	config, err := rest.InClusterConfig()
 	if err != nil {
@@ -104,87 +226,216 @@ func newFromEnvironment() (*config, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create vault client")
 	}
+	c.Authenticator, err = newAuthenticator()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to configure vault authenticator")
+	}
 	return c, nil
 }
 
-// loadToken from VaultTokenPath
-func (c *config) loadToken() (string, error) {
-	content, err := ioutil.ReadFile(c.VaultTokenPath)
-	if err != nil {
-		return "", errors.Wrap(err, "could not get vault token")
+// targetNamespace returns the namespace a mapping's secret should be
+// written to: its own override, or the synchronizer's namespace.
+func (c *config) targetNamespace(m *secretMapping) string {
+	if m.Namespace != "" {
+		return m.Namespace
 	}
-	return string(content), nil
+	return c.Namespace
 }
 
 // checkSecrets check the existence of a secret and not the content
 func (c *config) checkSecrets() error {
 	// check secrets
-	for k, v := range c.Secrets {
-		log.Println("check secret", k, "from vault secret", v)
-		_, err := c.k8sClientset.CoreV1().Secrets(c.Namespace).Get(k, metav1.GetOptions{})
+	for k, m := range c.Secrets {
+		log.Println("check secret", k.String(), "from vault secret", m.VaultPath)
+		_, err := c.k8sClientset.CoreV1().Secrets(k.Namespace).Get(k.Name, metav1.GetOptions{})
 		if err != nil {
-			return fmt.Errorf("secret %s does not exist", k)
+			return fmt.Errorf("secret %s does not exist in namespace %s", k.Name, k.Namespace)
 		}
 	}
 	return nil
 }
 
-// synchronize secret from vault to the current kubernetes namespace
-func (c *config) synchronize(token string) error {
+// kvVersionFor returns the KV engine version to use when reading a secret
+// under mount, resolving in order: an explicit VAULT_KV_MOUNTS override, the
+// global VAULT_KV_VERSION, then an auto-detected (and cached) value from
+// sys/mounts.
+func (c *config) kvVersionFor(mount string) (kvVersion, error) {
+	if v, ok := c.KVMounts[mount]; ok {
+		return v, nil
+	}
+	if c.KVVersion != "" {
+		return c.KVVersion, nil
+	}
+	if v, ok := c.kvVersions[mount]; ok {
+		return v, nil
+	}
+	v, err := detectKVVersion(c.vaultClient, mount)
+	if err != nil {
+		return "", err
+	}
+	c.kvVersions[mount] = v
+	return v, nil
+}
+
+// synchronize secrets from vault to their target kubernetes namespaces. In
+// FAILURE_MODE=fail-fast (the default) the first secret's failure aborts
+// the run; in best-effort mode every secret is attempted and only a
+// total failure is reported, see synchronizeBestEffort.
+func (c *config) synchronize(authSecret *api.Secret) error {
+	token, err := authSecret.TokenID()
+	if err != nil {
+		return errors.Wrap(err, "could not get vault token id")
+	}
 	c.vaultClient.SetToken(token)
-	// create/update the secrets
-	annotations := make(map[string]string)
-	for k, v := range c.Secrets {
-		// get secret from vault
-		log.Println("read", v, "from vault")
-		s, err := c.vaultClient.Logical().Read(v)
-		if err != nil {
+
+	if c.FailureMode == failureModeBestEffort {
+		return c.synchronizeBestEffort()
+	}
+
+	for k, m := range c.Secrets {
+		if _, _, err := c.applySecret(k, m); err != nil {
 			return err
 		}
-		// convert data
-		data := make(map[string][]byte)
-		for k, v := range s.Data["data"].(map[string]interface{}) {
-			data[k] = []byte(v.(string))
-		}
-		// create/update k8s secret
-		annotations[vaultAnnotation] = v
-		secret := &corev1.Secret{}
-		secret.Name = k
-		secret.Data = data
-		secret.Annotations = annotations
-		// create (insert) or update the secret
-		_, err = c.k8sClientset.CoreV1().Secrets(c.Namespace).Get(secret.Name, metav1.GetOptions{})
-		if apierr.IsNotFound(err) {
-			log.Println("create secret", secret.Name, "from vault secret", v)
-			if _, err := c.k8sClientset.CoreV1().Secrets(c.Namespace).Create(secret); err != nil {
-				return err
-			}
+	}
+	c.cleanupObsoleteSecrets()
+	return nil
+}
+
+// synchronizeBestEffort applies every secret, recording per-secret
+// failures into a syncReport instead of aborting, and only fails the
+// run when none of them succeeded.
+func (c *config) synchronizeBestEffort() error {
+	report := newSyncReport()
+	for k, m := range c.Secrets {
+		if _, _, err := c.applySecret(k, m); err != nil {
+			log.Println(errors.Wrapf(err, "failed to sync secret %s", k.String()))
+			report.recordFailure(k.String(), err)
 			continue
 		}
-		log.Println("update secret", secret.Name, "from vault secret", v)
-		if _, err = c.k8sClientset.CoreV1().Secrets(c.Namespace).Update(secret); err != nil {
-			return err
+		report.recordSuccess(k.String())
+	}
+	c.cleanupObsoleteSecrets()
+
+	if err := report.writeTo(os.Stdout); err != nil {
+		log.Println(errors.Wrap(err, "failed to write sync report"))
+	}
+	if err := c.publishReport(report); err != nil {
+		log.Println(errors.Wrap(err, "failed to publish sync status configmap"))
+	}
+
+	if report.succeeded() == 0 {
+		return fmt.Errorf("failed to synchronize any secret")
+	}
+	return nil
+}
+
+// applySecret reads the vault secret described by m and creates or
+// updates the kubernetes secret identified by key to match it. It
+// reports the secret's new identity (see secretPayload.identity) and
+// whether the kubernetes secret was actually written, skipping the write
+// when the payload's identity is unchanged from the last call.
+func (c *config) applySecret(key secretKey, m *secretMapping) (identity string, changed bool, err error) {
+	log.Println("read", m.VaultPath, "from vault")
+	version, err := c.kvVersionFor(mountOf(m.VaultPath))
+	if err != nil {
+		return "", false, errors.Wrapf(err, "could not resolve KV version for %s", m.VaultPath)
+	}
+	payload, err := readSecretData(c.vaultClient, m.VaultPath, version)
+	if err != nil {
+		return "", false, err
+	}
+	identity = payload.identity()
+	if c.secretIdentities[key] == identity {
+		return identity, false, nil
+	}
+
+	data, err := m.render(payload.Data)
+	if err != nil {
+		return "", false, err
+	}
+
+	secret := &corev1.Secret{}
+	secret.Name = key.Name
+	secret.Namespace = key.Namespace
+	secret.Type = m.Type
+	secret.Data = data
+	secret.Annotations = map[string]string{
+		vaultAnnotation:      m.VaultPath,
+		ownerAnnotation:      c.InstanceID,
+		sourceHashAnnotation: identity,
+	}
+
+	// create (insert) or update the secret
+	_, err = c.k8sClientset.CoreV1().Secrets(key.Namespace).Get(secret.Name, metav1.GetOptions{})
+	if apierr.IsNotFound(err) {
+		log.Println("create secret", key.String(), "from vault secret", m.VaultPath)
+		if _, err := c.k8sClientset.CoreV1().Secrets(key.Namespace).Create(secret); err != nil {
+			return "", false, err
+		}
+	} else {
+		log.Println("update secret", key.String(), "from vault secret", m.VaultPath)
+		if _, err := c.k8sClientset.CoreV1().Secrets(key.Namespace).Update(secret); err != nil {
+			return "", false, err
 		}
 	}
-	// delete obsolete secrets
-	secretList, err := c.k8sClientset.CoreV1().Secrets(c.Namespace).List(metav1.ListOptions{})
+
+	c.secretIdentities[key] = identity
+	return identity, true, nil
+}
+
+// cleanupObsoleteSecrets deletes kubernetes secrets owned by this
+// synchronizer instance that no longer have a matching entry in
+// c.Secrets. In cluster-scoped mode every namespace is considered;
+// otherwise only the synchronizer's own namespace and the namespaces
+// currently targeted by c.Secrets are, so an RBAC role restricted to
+// those namespaces is enough.
+func (c *config) cleanupObsoleteSecrets() {
+	if c.ClusterScoped {
+		c.cleanupNamespace(metav1.NamespaceAll)
+		return
+	}
+	namespaces := map[string]bool{c.Namespace: true}
+	for k := range c.Secrets {
+		namespaces[k.Namespace] = true
+	}
+	for ns := range namespaces {
+		c.cleanupNamespace(ns)
+	}
+}
+
+// cleanupNamespace deletes obsolete, synchronizer-owned vault secrets in
+// a single namespace ("" means every namespace).
+func (c *config) cleanupNamespace(namespace string) {
+	secretList, err := c.k8sClientset.CoreV1().Secrets(namespace).List(metav1.ListOptions{LabelSelector: c.LabelSelector})
 	if err != nil {
-		log.Println(errors.Wrap(err, "cleanup of unused vault secrets failed"))
-		os.Exit(0)
+		log.Println(errors.Wrapf(err, "cleanup of unused vault secrets in namespace %q failed", namespace))
+		return
 	}
 	for _, s := range secretList.Items {
 		// only secrets from vault
 		if _, ok := s.Annotations[vaultAnnotation]; !ok {
 			continue
 		}
-		// only if vault secret is not in secrets
-		if _, ok := c.Secrets[s.Name]; ok {
+		// never touch another syncer instance's secrets
+		if s.Annotations[ownerAnnotation] != c.InstanceID {
 			continue
 		}
-		log.Println("delete secret", s.Name)
-		if err := c.k8sClientset.CoreV1().Secrets(c.Namespace).Delete(s.Name, &metav1.DeleteOptions{}); err != nil {
-			log.Println(errors.Wrapf(err, "delete obsolete vault secret %s failed", s.Name))
+		// only if the secret is not (or no longer) one we manage
+		if c.isManaged(s.Namespace, s.Name) {
+			continue
+		}
+		log.Println("delete secret", s.Namespace+"/"+s.Name)
+		if err := c.k8sClientset.CoreV1().Secrets(s.Namespace).Delete(s.Name, &metav1.DeleteOptions{}); err != nil {
+			log.Println(errors.Wrapf(err, "delete obsolete vault secret %s/%s failed", s.Namespace, s.Name))
 		}
 	}
-	return nil
+}
+
+// isManaged reports whether name in namespace is the current target of
+// one of c.Secrets' mappings. Both namespace and name are part of the
+// key, so a secret with the same name managed in a different namespace
+// is never mistaken for this one.
+func (c *config) isManaged(namespace, name string) bool {
+	_, ok := c.Secrets[secretKey{Namespace: namespace, Name: name}]
+	return ok
 }
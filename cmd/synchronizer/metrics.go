@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// metrics tracks per-secret sync outcomes exposed via the daemon's
+// /metrics endpoint.
+type metrics struct {
+	mu        sync.Mutex
+	successes map[string]int
+	failures  map[string]int
+	lastSync  map[string]time.Time
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		successes: make(map[string]int),
+		failures:  make(map[string]int),
+		lastSync:  make(map[string]time.Time),
+	}
+}
+
+func (m *metrics) recordSuccess(secret string, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.successes[secret]++
+	m.lastSync[secret] = at
+}
+
+func (m *metrics) recordFailure(secret string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[secret]++
+}
+
+// writeTo renders the tracked counters in Prometheus text exposition
+// format.
+func (m *metrics) writeTo(w http.ResponseWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintln(w, "# HELP vault_kubernetes_sync_total Number of secret sync attempts by outcome.")
+	fmt.Fprintln(w, "# TYPE vault_kubernetes_sync_total counter")
+	for secret, n := range m.successes {
+		fmt.Fprintf(w, "vault_kubernetes_sync_total{secret=%q,outcome=\"success\"} %d\n", secret, n)
+	}
+	for secret, n := range m.failures {
+		fmt.Fprintf(w, "vault_kubernetes_sync_total{secret=%q,outcome=\"failure\"} %d\n", secret, n)
+	}
+	fmt.Fprintln(w, "# HELP vault_kubernetes_last_sync_timestamp_seconds Unix timestamp of the last successful sync.")
+	fmt.Fprintln(w, "# TYPE vault_kubernetes_last_sync_timestamp_seconds gauge")
+	for secret, t := range m.lastSync {
+		fmt.Fprintf(w, "vault_kubernetes_last_sync_timestamp_seconds{secret=%q} %d\n", secret, t.Unix())
+	}
+}
+
+// serve starts the /healthz and /metrics HTTP endpoints on addr and
+// blocks until the server stops.
+func (m *metrics) serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		m.writeTo(w)
+	})
+	return http.ListenAndServe(addr, mux)
+}
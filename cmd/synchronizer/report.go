@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	failureModeFailFast   = "fail-fast"
+	failureModeBestEffort = "best-effort"
+)
+
+// secretResult is one secret's outcome in a syncReport.
+type secretResult struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// syncReport is a structured, per-secret summary of a synchronize run,
+// written to stdout as JSON and, in best-effort mode, published to a
+// ConfigMap so it can be inspected after the pod exits.
+type syncReport struct {
+	Secrets map[string]secretResult `json:"secrets"`
+}
+
+func newSyncReport() *syncReport {
+	return &syncReport{Secrets: make(map[string]secretResult)}
+}
+
+func (r *syncReport) recordSuccess(name string) {
+	r.Secrets[name] = secretResult{Success: true}
+}
+
+func (r *syncReport) recordFailure(name string, err error) {
+	r.Secrets[name] = secretResult{Success: false, Error: err.Error()}
+}
+
+// succeeded counts how many secrets synchronized successfully.
+func (r *syncReport) succeeded() int {
+	n := 0
+	for _, res := range r.Secrets {
+		if res.Success {
+			n++
+		}
+	}
+	return n
+}
+
+// writeTo renders the report as JSON.
+func (r *syncReport) writeTo(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// statusConfigMapName returns the name of the ConfigMap a report should
+// be published to: "<pod>-vault-sync-status".
+func statusConfigMapName() (string, error) {
+	pod := os.Getenv("POD_NAME")
+	if pod != "" {
+		return pod + "-vault-sync-status", nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	return hostname + "-vault-sync-status", nil
+}
+
+// publishReport writes report as JSON to the "<pod>-vault-sync-status"
+// ConfigMap in c.Namespace, creating or updating it as needed. It is a
+// no-op outside of FAILURE_MODE=best-effort.
+func (c *config) publishReport(report *syncReport) error {
+	if c.FailureMode != failureModeBestEffort {
+		return nil
+	}
+	name, err := statusConfigMapName()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{}
+	cm.Name = name
+	cm.Data = map[string]string{"status.json": string(body)}
+
+	_, err = c.k8sClientset.CoreV1().ConfigMaps(c.Namespace).Get(name, metav1.GetOptions{})
+	if apierr.IsNotFound(err) {
+		_, err = c.k8sClientset.CoreV1().ConfigMaps(c.Namespace).Create(cm)
+		return err
+	}
+	_, err = c.k8sClientset.CoreV1().ConfigMaps(c.Namespace).Update(cm)
+	return err
+}
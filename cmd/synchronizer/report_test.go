@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSyncReport(t *testing.T) {
+	r := newSyncReport()
+	if r.succeeded() != 0 {
+		t.Fatalf("succeeded() on empty report = %d, want 0", r.succeeded())
+	}
+
+	r.recordSuccess("team-a/app-db")
+	r.recordFailure("team-b/app-db", fmt.Errorf("boom"))
+
+	if got, want := r.succeeded(), 1; got != want {
+		t.Errorf("succeeded() = %d, want %d", got, want)
+	}
+	if res := r.Secrets["team-a/app-db"]; !res.Success || res.Error != "" {
+		t.Errorf("Secrets[team-a/app-db] = %+v, want success with no error", res)
+	}
+	if res := r.Secrets["team-b/app-db"]; res.Success || res.Error != "boom" {
+		t.Errorf("Secrets[team-b/app-db] = %+v, want failure with error 'boom'", res)
+	}
+
+	var buf bytes.Buffer
+	if err := r.writeTo(&buf); err != nil {
+		t.Fatalf("writeTo: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "team-a/app-db") || !strings.Contains(out, "boom") {
+		t.Errorf("writeTo output missing expected content: %s", out)
+	}
+}
+
+func TestStatusConfigMapName(t *testing.T) {
+	t.Run("uses POD_NAME when set", func(t *testing.T) {
+		t.Setenv("POD_NAME", "myapp-7d8f")
+		name, err := statusConfigMapName()
+		if err != nil {
+			t.Fatalf("statusConfigMapName: %v", err)
+		}
+		if want := "myapp-7d8f-vault-sync-status"; name != want {
+			t.Errorf("statusConfigMapName() = %q, want %q", name, want)
+		}
+	})
+
+	t.Run("falls back to hostname", func(t *testing.T) {
+		t.Setenv("POD_NAME", "")
+		hostname, err := os.Hostname()
+		if err != nil {
+			t.Skipf("os.Hostname unavailable: %v", err)
+		}
+		name, err := statusConfigMapName()
+		if err != nil {
+			t.Fatalf("statusConfigMapName: %v", err)
+		}
+		if want := hostname + "-vault-sync-status"; name != want {
+			t.Errorf("statusConfigMapName() = %q, want %q", name, want)
+		}
+	})
+}
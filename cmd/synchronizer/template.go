@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// secretMapping describes how one VAULT_SECRETS entry should be rendered
+// into a Kubernetes secret: which Vault path to read, what Kubernetes
+// secret name, namespace and type to produce, and (optionally) a
+// per-field template projecting Vault fields into the secret's Data.
+//
+// The VAULT_SECRETS DSL for one entry is:
+//
+//	path[:name[@namespace]][|type=kubernetes-secret-type][|field=go-template]...
+//
+// e.g. vault/pki/issue/web:web-tls|type=tls|tls.crt={{.certificate}}|tls.key={{.private_key}}
+// or vault/app/db:app-db@team-a to land the secret in another namespace.
+//
+// Entries are separated by "," and options within an entry by "|", so a
+// field template that needs a literal comma or pipe (e.g. building a
+// dockerconfigjson payload) must escape it as "\," or "\|"; a literal
+// backslash is written "\\". \n still denotes a literal newline, since
+// the template text itself comes from a single-line environment
+// variable.
+//
+// When no field templates are given, Fields is empty and every Vault
+// field is copied verbatim into Data, preserving the original DSL's
+// behaviour.
+type secretMapping struct {
+	VaultPath string
+	Name      string
+	Namespace string // target namespace override, "" means the synchronizer's own namespace
+	Type      corev1.SecretType
+	Fields    map[string]*template.Template
+}
+
+// secretTypeAliases maps the short type names used in the VAULT_SECRETS
+// DSL to their Kubernetes secret type.
+var secretTypeAliases = map[string]corev1.SecretType{
+	"opaque":           corev1.SecretTypeOpaque,
+	"tls":              corev1.SecretTypeTLS,
+	"dockerconfigjson": corev1.SecretTypeDockerConfigJson,
+	"basic-auth":       corev1.SecretTypeBasicAuth,
+}
+
+// requiredFieldsByType lists the Data keys a secret of a given type must
+// carry before it is safe to send to the API server.
+var requiredFieldsByType = map[corev1.SecretType][]string{
+	corev1.SecretTypeTLS:              {corev1.TLSCertKey, corev1.TLSPrivateKeyKey},
+	corev1.SecretTypeDockerConfigJson: {corev1.DockerConfigJsonKey},
+	corev1.SecretTypeBasicAuth:        {corev1.BasicAuthUsernameKey, corev1.BasicAuthPasswordKey},
+}
+
+// parseSecretType resolves a type= DSL value, accepting both the short
+// aliases (tls, dockerconfigjson, basic-auth, opaque) and the full
+// kubernetes.io/... secret type strings.
+func parseSecretType(value string) (corev1.SecretType, error) {
+	if t, ok := secretTypeAliases[value]; ok {
+		return t, nil
+	}
+	switch t := corev1.SecretType(value); t {
+	case corev1.SecretTypeOpaque, corev1.SecretTypeTLS, corev1.SecretTypeDockerConfigJson, corev1.SecretTypeBasicAuth:
+		return t, nil
+	}
+	return "", fmt.Errorf("unsupported secret type %q", value)
+}
+
+// splitUnescaped splits s on sep, except where sep is itself escaped with
+// a leading backslash (e.g. "\," to keep a literal comma inside a ","
+// separated list). "\\" is unescaped to a literal backslash; any other
+// backslash sequence, such as the \n newline marker, is left untouched
+// for later stages to interpret.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	var cur []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == sep || s[i+1] == '\\') {
+			cur = append(cur, s[i+1])
+			i++
+			continue
+		}
+		if s[i] == sep {
+			parts = append(parts, string(cur))
+			cur = cur[:0]
+			continue
+		}
+		cur = append(cur, s[i])
+	}
+	return append(parts, string(cur))
+}
+
+// parseSecretMapping parses one comma-separated entry of VAULT_SECRETS.
+func parseSecretMapping(item string) (*secretMapping, error) {
+	parts := splitUnescaped(item, '|')
+
+	ref := strings.SplitN(parts[0], ":", 2)
+	vaultPath := ref[0]
+	name := path.Base(vaultPath)
+	namespace := ""
+	if len(ref) > 1 {
+		nameRef := strings.SplitN(ref[1], "@", 2)
+		name = nameRef[0]
+		if len(nameRef) > 1 {
+			namespace = nameRef[1]
+		}
+	}
+
+	m := &secretMapping{
+		VaultPath: vaultPath,
+		Name:      name,
+		Namespace: namespace,
+		Type:      corev1.SecretTypeOpaque,
+		Fields:    make(map[string]*template.Template),
+	}
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid option %q, expected key=value", opt)
+		}
+		key, value := kv[0], kv[1]
+		if key == "type" {
+			t, err := parseSecretType(value)
+			if err != nil {
+				return nil, err
+			}
+			m.Type = t
+			continue
+		}
+		// \n in the DSL denotes a literal newline, since the template text
+		// itself comes from a single-line environment variable.
+		value = strings.ReplaceAll(value, `\n`, "\n")
+		tmpl, err := template.New(key).Parse(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid template for field %s", key)
+		}
+		m.Fields[key] = tmpl
+	}
+	return m, nil
+}
+
+// render projects vaultData into the secret's Data according to the
+// mapping's field templates, or copies it verbatim if none were given.
+// It then validates that every field required by the secret's type is
+// present, so a partial secret never reaches the API server.
+func (m *secretMapping) render(vaultData map[string]interface{}) (map[string][]byte, error) {
+	var data map[string][]byte
+	if len(m.Fields) == 0 {
+		data = make(map[string][]byte, len(vaultData))
+		for k, v := range vaultData {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("field %s of secret %s is not a string", k, m.VaultPath)
+			}
+			data[k] = []byte(s)
+		}
+	} else {
+		data = make(map[string][]byte, len(m.Fields))
+		for key, tmpl := range m.Fields {
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, vaultData); err != nil {
+				return nil, errors.Wrapf(err, "failed to render field %s of secret %s", key, m.VaultPath)
+			}
+			data[key] = buf.Bytes()
+		}
+	}
+
+	for _, key := range requiredFieldsByType[m.Type] {
+		if _, ok := data[key]; !ok {
+			return nil, fmt.Errorf("secret %s of type %s is missing required field %s", m.VaultPath, m.Type, key)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSplitUnescaped(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		sep  byte
+		want []string
+	}{
+		{"simple", "a,b,c", ',', []string{"a", "b", "c"}},
+		{"escaped separator kept literal", `a\,b,c`, ',', []string{"a,b", "c"}},
+		{"escaped backslash", `a\\,b`, ',', []string{`a\`, "b"}},
+		{"unrelated backslash untouched", `a\nb,c`, ',', []string{`a\nb`, "c"}},
+		{"empty string yields one empty part", "", ',', []string{""}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := splitUnescaped(tc.in, tc.sep)
+			if len(got) != len(tc.want) {
+				t.Fatalf("splitUnescaped(%q, %q) = %q, want %q", tc.in, tc.sep, got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("splitUnescaped(%q, %q)[%d] = %q, want %q", tc.in, tc.sep, i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseSecretMappingAndRender(t *testing.T) {
+	t.Run("tls", func(t *testing.T) {
+		m, err := parseSecretMapping(`vault/pki/issue/web:web-tls|type=tls|tls.crt={{.certificate}}\n{{.ca_chain}}|tls.key={{.private_key}}`)
+		if err != nil {
+			t.Fatalf("parseSecretMapping: %v", err)
+		}
+		if m.Name != "web-tls" || m.Type != corev1.SecretTypeTLS {
+			t.Fatalf("unexpected mapping: %+v", m)
+		}
+		data, err := m.render(map[string]interface{}{
+			"certificate": "CERT",
+			"ca_chain":    "CHAIN",
+			"private_key": "KEY",
+		})
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		if got, want := string(data[corev1.TLSCertKey]), "CERT\nCHAIN"; got != want {
+			t.Errorf("tls.crt = %q, want %q", got, want)
+		}
+		if got, want := string(data[corev1.TLSPrivateKeyKey]), "KEY"; got != want {
+			t.Errorf("tls.key = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("dockerconfigjson with escaped commas", func(t *testing.T) {
+		// The literal comma inside the JSON payload is escaped as "\,"
+		// so the top-level VAULT_SECRETS split (on unescaped ",") does
+		// not shred this single entry, mirroring real VAULT_SECRETS
+		// parsing in newFromEnvironment.
+		env := `vault/registry/creds:regcred|type=dockerconfigjson|.dockerconfigjson={"auths":{"{{.host}}":{"username":"{{.username}}"\,"password":"{{.password}}"}}}`
+		entries := splitUnescaped(env, ',')
+		if len(entries) != 1 {
+			t.Fatalf("splitUnescaped split the escaped comma: got %d entries: %q", len(entries), entries)
+		}
+		m, err := parseSecretMapping(entries[0])
+		if err != nil {
+			t.Fatalf("parseSecretMapping: %v", err)
+		}
+		if m.Type != corev1.SecretTypeDockerConfigJson {
+			t.Fatalf("unexpected type: %v", m.Type)
+		}
+		data, err := m.render(map[string]interface{}{
+			"host":     "registry.example.com",
+			"username": "alice",
+			"password": "hunter2",
+		})
+		if err != nil {
+			t.Fatalf("render: %v", err)
+		}
+		want := `{"auths":{"registry.example.com":{"username":"alice","password":"hunter2"}}}`
+		if got := string(data[corev1.DockerConfigJsonKey]); got != want {
+			t.Errorf(".dockerconfigjson = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("basic-auth missing required field", func(t *testing.T) {
+		m, err := parseSecretMapping(`vault/app/db:db-creds|type=basic-auth|username={{.username}}`)
+		if err != nil {
+			t.Fatalf("parseSecretMapping: %v", err)
+		}
+		if _, err := m.render(map[string]interface{}{"username": "alice"}); err == nil {
+			t.Fatal("expected render to fail on missing password field")
+		}
+	})
+
+	t.Run("malformed option", func(t *testing.T) {
+		if _, err := parseSecretMapping("vault/app/db:app-db|type"); err == nil {
+			t.Fatal("expected error for option without '='")
+		}
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		if _, err := parseSecretMapping("vault/app/db:app-db|type=bogus"); err == nil {
+			t.Fatal("expected error for unsupported secret type")
+		}
+	})
+
+	t.Run("namespace override", func(t *testing.T) {
+		m, err := parseSecretMapping("vault/app/db:app-db@team-a")
+		if err != nil {
+			t.Fatalf("parseSecretMapping: %v", err)
+		}
+		if m.Namespace != "team-a" {
+			t.Fatalf("Namespace = %q, want team-a", m.Namespace)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// kvVersion identifies which flavour of the Vault KV secrets engine
+// backs a given mount.
+type kvVersion string
+
+const (
+	kvVersionV1 kvVersion = "v1"
+	kvVersionV2 kvVersion = "v2"
+)
+
+// secretPayload is a Vault secret's data together with enough metadata to
+// tell whether it has changed since it was last read.
+type secretPayload struct {
+	Data    map[string]interface{}
+	LeaseID string
+	Version string // KV v2 version number, empty for v1 and non-versioned secrets
+}
+
+// identity returns a value that changes whenever the secret's content
+// does: the KV v2 version when available, the lease ID for leased
+// secrets, or a hash of Data as a fallback for static KV v1 secrets.
+func (p *secretPayload) identity() string {
+	if p.Version != "" {
+		return "version:" + p.Version
+	}
+	if p.LeaseID != "" {
+		return "lease:" + p.LeaseID
+	}
+	return "hash:" + hashOf(p.Data)
+}
+
+// hashOf returns a stable hash of a secret data map, independent of key
+// ordering.
+func hashOf(data map[string]interface{}) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		v, _ := json.Marshal(data[k])
+		h.Write([]byte(k))
+		h.Write(v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// readSecretData reads the Vault secret at path and returns a
+// secretPayload whose Data is a flat map of field name to value,
+// regardless of whether the backing mount is a KV v1 or KV v2 engine.
+//
+// version selects the dispatch explicitly; pass "" to auto-detect it
+// from the mount via sys/mounts.
+func readSecretData(client *api.Client, path string, version kvVersion) (*secretPayload, error) {
+	mount := mountOf(path)
+	if version == "" {
+		detected, err := detectKVVersion(client, mount)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not detect KV version for mount %s", mount)
+		}
+		version = detected
+	}
+
+	readPath := path
+	if version == kvVersionV2 {
+		readPath = toDataPath(mount, path)
+	}
+
+	s, err := client.Logical().Read(readPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read secret %s", readPath)
+	}
+	if s == nil {
+		return nil, fmt.Errorf("secret %s does not exist", readPath)
+	}
+
+	switch version {
+	case kvVersionV2:
+		data, ok := s.Data["data"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("secret %s does not look like a KV v2 payload (missing data field)", readPath)
+		}
+		payload := &secretPayload{Data: data, LeaseID: s.LeaseID}
+		if meta, ok := s.Data["metadata"].(map[string]interface{}); ok {
+			if v, ok := meta["version"].(json.Number); ok {
+				payload.Version = v.String()
+			} else if v, ok := meta["version"].(float64); ok {
+				payload.Version = fmt.Sprintf("%.0f", v)
+			}
+		}
+		return payload, nil
+	default:
+		if len(s.Data) == 0 {
+			return nil, fmt.Errorf("secret %s does not look like a KV v1 payload (empty data)", readPath)
+		}
+		return &secretPayload{Data: s.Data, LeaseID: s.LeaseID}, nil
+	}
+}
+
+// mountOf returns the top-level mount path of a Vault secret path, e.g.
+// mountOf("secret/foo/bar") == "secret".
+func mountOf(path string) string {
+	if i := strings.Index(path, "/"); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// toDataPath rewrites a KV v2 logical path (mount/rest) into its storage
+// path (mount/data/rest), as required by the KV v2 HTTP API.
+func toDataPath(mount, path string) string {
+	rest := strings.TrimPrefix(path, mount+"/")
+	return mount + "/data/" + rest
+}
+
+// detectKVVersion queries sys/mounts to determine whether mount is
+// backed by the KV v1 or KV v2 secrets engine.
+func detectKVVersion(client *api.Client, mount string) (kvVersion, error) {
+	mounts, err := client.Sys().ListMounts()
+	if err != nil {
+		return "", err
+	}
+	m, ok := mounts[mount+"/"]
+	if !ok {
+		return "", fmt.Errorf("no such mount %s", mount)
+	}
+	if m.Options["version"] == "2" {
+		return kvVersionV2, nil
+	}
+	return kvVersionV1, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestMountOf(t *testing.T) {
+	cases := map[string]string{
+		"secret/foo/bar": "secret",
+		"kv/app":         "kv",
+		"secret":         "secret",
+	}
+	for path, want := range cases {
+		if got := mountOf(path); got != want {
+			t.Errorf("mountOf(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestToDataPath(t *testing.T) {
+	cases := []struct{ mount, path, want string }{
+		{"secret", "secret/app/db", "secret/data/app/db"},
+		{"kv", "kv/foo", "kv/data/foo"},
+	}
+	for _, tc := range cases {
+		if got := toDataPath(tc.mount, tc.path); got != tc.want {
+			t.Errorf("toDataPath(%q, %q) = %q, want %q", tc.mount, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestDetectKVVersion(t *testing.T) {
+	cases := []struct {
+		name    string
+		options map[string]string
+		want    kvVersion
+	}{
+		{"v2", map[string]string{"version": "2"}, kvVersionV2},
+		{"v1 explicit", map[string]string{"version": "1"}, kvVersionV1},
+		{"v1 default (no options)", nil, kvVersionV1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// sys/mounts wraps its payload in a "data" field like
+				// every other Vault API response; ListMounts reads
+				// secret.Data, so an unwrapped body looks empty to it.
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"data": map[string]interface{}{
+						"secret/": map[string]interface{}{
+							"type":    "kv",
+							"options": tc.options,
+						},
+					},
+				})
+			}))
+			defer ts.Close()
+
+			client, err := api.NewClient(&api.Config{Address: ts.URL})
+			if err != nil {
+				t.Fatalf("api.NewClient: %v", err)
+			}
+
+			got, err := detectKVVersion(client, "secret")
+			if err != nil {
+				t.Fatalf("detectKVVersion: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("detectKVVersion() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("unknown mount", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{
+					"other/": map[string]interface{}{"type": "kv"},
+				},
+			})
+		}))
+		defer ts.Close()
+
+		client, err := api.NewClient(&api.Config{Address: ts.URL})
+		if err != nil {
+			t.Fatalf("api.NewClient: %v", err)
+		}
+		if _, err := detectKVVersion(client, "secret"); err == nil {
+			t.Fatal("expected error for unknown mount")
+		}
+	})
+}